@@ -0,0 +1,25 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha2
+
+// GenericRef represents a reference to a generic object, identified by its name and namespace.
+type GenericRef struct {
+	// The name of the resource.
+	Name string `json:"name"`
+	// The namespace containing the resource. It defaults to the same namespace of the
+	// referring object, when not specified.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
@@ -0,0 +1,77 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InstanceCustomizationUrls contains the set of external URLs the Instance should be integrated with.
+type InstanceCustomizationUrls struct {
+	// The URL to be contacted to retrieve the status of the Instance, used to drive the
+	// automatic termination of inactive Instances.
+	// +optional
+	StatusCheck string `json:"statusCheck,omitempty"`
+}
+
+// InstanceSpec defines the desired state of Instance.
+type InstanceSpec struct {
+	// The reference to the Template this Instance derives from.
+	Template GenericRef `json:"template.crownlabs.polito.it/TemplateRef"`
+	// The reference to the Tenant owning this Instance.
+	Tenant GenericRef `json:"tenant.crownlabs.polito.it/TenantRef"`
+	// Whether the current instance is running or not.
+	// +optional
+	Running bool `json:"running,omitempty"`
+	// The human-readable name of the Instance.
+	// +optional
+	PrettyName string `json:"prettyName,omitempty"`
+	// The set of external URLs the Instance should be integrated with.
+	// +optional
+	CustomizationUrls *InstanceCustomizationUrls `json:"customizationUrls,omitempty"`
+}
+
+// InstanceStatus defines the observed state of Instance.
+type InstanceStatus struct {
+	// The set of conditions characterizing the current state of the Instance, as produced by
+	// the reconciliation logic (e.g. label evaluation errors).
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// Instance is the Schema for the instances API.
+type Instance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InstanceSpec   `json:"spec,omitempty"`
+	Status InstanceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// InstanceList contains a list of Instance.
+type InstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Instance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Instance{}, &InstanceList{})
+}
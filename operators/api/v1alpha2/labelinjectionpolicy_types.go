@@ -0,0 +1,64 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabelInjectionPolicySpec defines the desired state of LabelInjectionPolicy.
+type LabelInjectionPolicySpec struct {
+	// The selector used to match the Instances (e.g. by tenant, workspace or template labels)
+	// this policy applies to.
+	Selector metav1.LabelSelector `json:"selector"`
+	// The labels to be injected into the matching Instances. Built-in crownlabs.polito.it/*
+	// labels always win over the ones configured here.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// The annotations to be injected into the matching Instances.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// LabelInjectionPolicyStatus defines the observed state of LabelInjectionPolicy.
+type LabelInjectionPolicyStatus struct {
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// LabelInjectionPolicy is the Schema for the labelinjectionpolicies API. It allows cluster admins
+// to declaratively attach extra labels and annotations (e.g. cost-center or classroom tags) to the
+// Instances matching a given selector, without patching the operator.
+type LabelInjectionPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LabelInjectionPolicySpec   `json:"spec,omitempty"`
+	Status LabelInjectionPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// LabelInjectionPolicyList contains a list of LabelInjectionPolicy.
+type LabelInjectionPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LabelInjectionPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LabelInjectionPolicy{}, &LabelInjectionPolicyList{})
+}
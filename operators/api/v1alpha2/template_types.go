@@ -0,0 +1,127 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EnvironmentType represents the type of a CrownLabs environment.
+type EnvironmentType string
+
+const (
+	// ClassVM refers to a VM based environment.
+	ClassVM EnvironmentType = "VirtualMachine"
+	// ClassContainer refers to a container based environment.
+	ClassContainer EnvironmentType = "Container"
+)
+
+// EnvironmentResources contains the amount of resources assigned to a given environment.
+type EnvironmentResources struct {
+	// The maximum amount of CPU cores can be used.
+	CPU uint32 `json:"cpu"`
+	// The percentage of the CPU cores reserved.
+	ReservedCPUPercentage uint32 `json:"reservedCPUPercentage"`
+	// The amount of RAM memory assigned to the environment.
+	Memory string `json:"memory"`
+	// The amount of storage assigned to the environment.
+	// +optional
+	Disk string `json:"disk,omitempty"`
+}
+
+// Environment describes the characteristics of a single environment composing a given Template.
+type Environment struct {
+	// The name identifying the specific environment.
+	Name string `json:"name"`
+	// The description of the environment image.
+	Image string `json:"image"`
+	// The type of environment to be instantiated.
+	EnvironmentType EnvironmentType `json:"environmentType"`
+	// Whether the environment has a GUI.
+	GuiEnabled bool `json:"guiEnabled,omitempty"`
+	// Whether the environment is persistent, hence preserving its state across restarts.
+	Persistent bool `json:"persistent,omitempty"`
+	// The amount of resources associated with the environment.
+	Resources EnvironmentResources `json:"resources"`
+}
+
+// TemplateSpec defines the desired state of Template.
+type TemplateSpec struct {
+	// The human-readable name of the Template.
+	PrettyName string `json:"prettyName"`
+	// A textual description of the Template.
+	// +optional
+	Description string `json:"description,omitempty"`
+	// The list of environments that compose the Template.
+	EnvironmentList []Environment `json:"environmentList"`
+	// The reference to the Workspace this Template belongs to.
+	WorkspaceRef GenericRef `json:"workspaceRef"`
+	// The amount of time, counted from their creation, after which the Instances of this Template
+	// are automatically deleted, expressed in the format d|dd, for no automatic deletion this field must be
+	// set to "never".
+	// +optional
+	// +kubebuilder:default=never
+	DeleteAfter string `json:"deleteAfter,omitempty"`
+	// The set of CEL expressions evaluated to compute additional labels for the
+	// Instances referencing this Template, on top of the built-in ones.
+	// +optional
+	LabelExprs []MapExpr `json:"labelExprs,omitempty"`
+}
+
+// MapExpr represents a single key/value pair whose content may be computed at runtime through a
+// CEL expression, rather than being statically defined.
+type MapExpr struct {
+	// The static key, used when KeyExpr is not specified.
+	// +optional
+	Key string `json:"key,omitempty"`
+	// A CEL expression evaluated to compute the key. It takes precedence over Key, when specified.
+	// +optional
+	KeyExpr string `json:"keyExpr,omitempty"`
+	// The static value, used when ValueExpr is not specified.
+	// +optional
+	Value string `json:"value,omitempty"`
+	// A CEL expression evaluated to compute the value. It takes precedence over Value, when specified.
+	// +optional
+	ValueExpr string `json:"valueExpr,omitempty"`
+}
+
+// TemplateStatus defines the observed state of Template.
+type TemplateStatus struct {
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// Template is the Schema for the templates API.
+type Template struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TemplateSpec   `json:"spec,omitempty"`
+	Status TemplateStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TemplateList contains a list of Template.
+type TemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Template `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Template{}, &TemplateList{})
+}
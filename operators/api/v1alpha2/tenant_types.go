@@ -0,0 +1,80 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TenantWorkspaceEntry represents the reference to a Workspace a given Tenant is subscribed to.
+type TenantWorkspaceEntry struct {
+	// The reference to the Workspace.
+	WorkspaceRef GenericRef `json:"workspaceRef"`
+	// The role of the Tenant within the Workspace.
+	// +optional
+	Role string `json:"role,omitempty"`
+}
+
+// TenantSpec defines the desired state of Tenant.
+type TenantSpec struct {
+	// The first name of the Tenant.
+	FirstName string `json:"firstName"`
+	// The last name of the Tenant.
+	LastName string `json:"lastName"`
+	// The email address of the Tenant.
+	Email string `json:"email"`
+	// The list of Workspaces the Tenant is subscribed to.
+	// +optional
+	Workspaces []TenantWorkspaceEntry `json:"workspaces,omitempty"`
+}
+
+// TenantStatus defines the observed state of Tenant.
+type TenantStatus struct {
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// Tenant is the Schema for the tenants API.
+type Tenant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TenantSpec   `json:"spec,omitempty"`
+	Status TenantStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TenantList contains a list of Tenant.
+type TenantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Tenant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Tenant{}, &TenantList{})
+}
+
+// HasWorkspace returns whether the Tenant is subscribed to the Workspace with the given name.
+func (t *Tenant) HasWorkspace(name string) bool {
+	for _, ws := range t.Spec.Workspaces {
+		if ws.WorkspaceRef.Name == name {
+			return true
+		}
+	}
+	return false
+}
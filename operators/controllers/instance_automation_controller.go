@@ -0,0 +1,63 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/forge"
+)
+
+// InstanceAutomationReconciler reacts to Instances marked drifted or expired by
+// forge.InstanceDriftLabels/forge.InstanceExpirationLabels (as computed by the InstanceReconciler),
+// driving them through the same termination/resubmission flow already used for status-check-based
+// automation.
+type InstanceAutomationReconciler struct {
+	client.Client
+}
+
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=instances,verbs=get;list;watch;update;patch
+
+// Reconcile marks the given Instance for termination and status resubmission, through
+// forge.InstanceAutomationLabelsOnTermination, the same way the status-check automation already
+// does. It is only ever invoked for Instances selected by forge.DriftedOrExpiredPredicate.
+func (r *InstanceAutomationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var instance clv1alpha2.Instance
+	if err := r.Get(ctx, req.NamespacedName, &instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if instance.Labels[forge.InstanceTerminationSelectorLabel] == "false" &&
+		instance.Labels[forge.InstanceSubmitterSelectorLabel] == "true" {
+		return ctrl.Result{}, nil
+	}
+
+	instance.Labels = forge.InstanceAutomationLabelsOnTermination(instance.Labels)
+	return ctrl.Result{}, r.Update(ctx, &instance)
+}
+
+// SetupWithManager registers the InstanceAutomationReconciler with the given Manager, restricting
+// it to the Instances currently marked as drifted or expired through
+// forge.DriftedOrExpiredPredicate.
+func (r *InstanceAutomationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clv1alpha2.Instance{}, builder.WithPredicates(forge.DriftedOrExpiredPredicate())).
+		Complete(r)
+}
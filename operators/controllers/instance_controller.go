@@ -0,0 +1,129 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package controllers contains the reconciliation logic of the CrownLabs custom resources.
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	clv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/forge"
+)
+
+// InstanceReconciler reconciles an Instance object.
+type InstanceReconciler struct {
+	client.Client
+}
+
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=instances,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=templates,verbs=get;list;watch
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=labelinjectionpolicies,verbs=get;list;watch
+
+// Reconcile implements the reconciliation logic for an Instance object. Before anything else, it
+// migrates the Instance labels to the current forge.LabelSchemaVersion through forge.MigrateLabels,
+// so that the rest of the reconciliation always operates on an up-to-date label set. It then
+// recomputes the Instance labels out of the referenced Template and the LabelInjectionPolicy
+// objects currently in the manager's cache, together with the drift/expiration selector labels,
+// persisting the result whenever it changed. On success, it stamps the SpecHashAnnotation with the
+// hash of the just-reconciled environment spec, so that the next reconcile can detect drift.
+func (r *InstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var instance clv1alpha2.Instance
+	if err := r.Get(ctx, req.NamespacedName, &instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if migrated, updated := forge.MigrateLabels(instance.Labels); updated {
+		instance.Labels = migrated
+		if err := r.Update(ctx, &instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	templateName := types.NamespacedName{Namespace: instance.Spec.Template.Namespace, Name: instance.Spec.Template.Name}
+	if templateName.Namespace == "" {
+		templateName.Namespace = instance.Namespace
+	}
+	var template clv1alpha2.Template
+	if err := r.Get(ctx, templateName, &template); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var policies clv1alpha2.LabelInjectionPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	labels, updated := forge.InstanceLabels(instance.Labels, &instance, &template, nil, nil, instance.Spec.CustomizationUrls, policies.Items)
+
+	labels, driftUpdated := forge.InstanceDriftLabels(labels, &instance, &template)
+	updated = updated || driftUpdated
+
+	labels, expirationUpdated := forge.InstanceExpirationLabels(labels, &instance, &template, time.Now())
+	updated = updated || expirationUpdated
+
+	if updated {
+		instance.Labels = labels
+		if err := r.Update(ctx, &instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	currentHash := forge.SpecHash(&instance, &template)
+	if instance.Annotations[forge.SpecHashAnnotation] != currentHash {
+		if instance.Annotations == nil {
+			instance.Annotations = map[string]string{}
+		}
+		instance.Annotations[forge.SpecHashAnnotation] = currentHash
+		if err := r.Update(ctx, &instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager registers the InstanceReconciler with the given Manager, additionally watching
+// the LabelInjectionPolicy objects so that a change to one of them (e.g. its selector or payload)
+// triggers a re-reconciliation of every Instance, keeping the injected labels up to date.
+func (r *InstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clv1alpha2.Instance{}).
+		Watches(&clv1alpha2.LabelInjectionPolicy{}, handler.EnqueueRequestsFromMapFunc(r.allInstances)).
+		Complete(r)
+}
+
+// allInstances maps a LabelInjectionPolicy event to a reconcile request for every Instance
+// currently known to the cache, since a single policy may affect any number of them.
+func (r *InstanceReconciler) allInstances(ctx context.Context, _ client.Object) []ctrl.Request {
+	var instances clv1alpha2.InstanceList
+	if err := r.List(ctx, &instances); err != nil {
+		return nil
+	}
+
+	requests := make([]ctrl.Request, len(instances.Items))
+	for i := range instances.Items {
+		requests[i] = ctrl.Request{NamespacedName: types.NamespacedName{
+			Namespace: instances.Items[i].Namespace,
+			Name:      instances.Items[i].Name,
+		}}
+	}
+	return requests
+}
@@ -0,0 +1,137 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	clv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+const (
+	// SpecHashAnnotation is the annotation storing the hash of the resolved environment spec, as
+	// computed at the last successful reconcile, used to detect configuration drift.
+	SpecHashAnnotation = crownLabsPrefix + "spec-hash"
+
+	// InstanceDriftedSelectorLabel is the label used to mark an Instance whose resolved
+	// environment spec no longer matches the one captured in the SpecHashAnnotation. The
+	// instance-automation controller marks such an Instance for termination, the same way as an
+	// expired one; it does not rebuild it.
+	InstanceDriftedSelectorLabel = crownLabsPrefix + "drifted"
+	// InstanceExpiredSelectorLabel is the label used to mark an Instance whose Template.Spec.DeleteAfter
+	// has elapsed since its creation, making it a candidate for termination by the
+	// instance-automation controller.
+	InstanceExpiredSelectorLabel = crownLabsPrefix + "expired"
+)
+
+// InstanceDriftLabels receives in input a set of labels and returns the updated set depending on
+// whether the hash of the environment spec resolved from the given Template and Instance differs
+// from the one captured in the SpecHashAnnotation at the last successful reconcile. It returns
+// the updated set of labels, together with a boolean value indicating whether it differs from the
+// input one (hence, whether it should be updated).
+func InstanceDriftLabels(labels map[string]string, instance *clv1alpha2.Instance, template *clv1alpha2.Template) (map[string]string, bool) {
+	labels = deepCopyLabels(labels)
+
+	lastHash := instance.Annotations[SpecHashAnnotation]
+	drifted := lastHash != "" && lastHash != SpecHash(instance, template)
+
+	return setOrClearLabel(labels, InstanceDriftedSelectorLabel, drifted)
+}
+
+// InstanceExpirationLabels receives in input a set of labels and returns the updated set depending
+// on whether the Template.Spec.DeleteAfter duration has elapsed since the Instance creation, with
+// respect to the given reference time. It returns the updated set of labels, together with a
+// boolean value indicating whether it differs from the input one (hence, whether it should be
+// updated).
+func InstanceExpirationLabels(labels map[string]string, instance *clv1alpha2.Instance, template *clv1alpha2.Template, now time.Time) (map[string]string, bool) {
+	labels = deepCopyLabels(labels)
+
+	deleteAfter, ok := parseDeleteAfter(template.Spec.DeleteAfter)
+	expired := ok && now.After(instance.CreationTimestamp.Add(deleteAfter))
+
+	return setOrClearLabel(labels, InstanceExpiredSelectorLabel, expired)
+}
+
+// SpecHash computes a stable hash of the environment spec resolved from the given Instance and
+// Template (image, resources, persistent flag and customization URLs), to be compared against the
+// SpecHashAnnotation in order to detect configuration drift.
+func SpecHash(instance *clv1alpha2.Instance, template *clv1alpha2.Template) string {
+	var sb strings.Builder
+
+	for i := range template.Spec.EnvironmentList {
+		env := &template.Spec.EnvironmentList[i]
+		fmt.Fprintf(&sb, "%s|%s|%t|%d|%d|%s|%s;",
+			env.Name, env.Image, env.Persistent,
+			env.Resources.CPU, env.Resources.ReservedCPUPercentage, env.Resources.Memory, env.Resources.Disk)
+	}
+
+	if instance.Spec.CustomizationUrls != nil {
+		fmt.Fprintf(&sb, "%s", instance.Spec.CustomizationUrls.StatusCheck)
+	}
+
+	sum := sha256.Sum256([]byte(sb.String()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// parseDeleteAfter parses the Template.Spec.DeleteAfter field, expressed as a number of days or
+// as "never". It returns the parsed duration, together with a boolean indicating whether an
+// automatic expiration is configured at all.
+func parseDeleteAfter(deleteAfter string) (time.Duration, bool) {
+	if deleteAfter == "" || deleteAfter == "never" {
+		return 0, false
+	}
+
+	days, err := strconv.Atoi(deleteAfter)
+	if err != nil || days <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(days) * 24 * time.Hour, true
+}
+
+// setOrClearLabel sets the given label to "true" when active is true, otherwise it removes it
+// from the labels map. It returns the updated map together with a boolean indicating whether it
+// differs from the one passed as input.
+func setOrClearLabel(labels map[string]string, key string, active bool) (map[string]string, bool) {
+	if active {
+		return labels, updateLabel(labels, key, strconv.FormatBool(true))
+	}
+
+	if _, found := labels[key]; found {
+		delete(labels, key)
+		return labels, true
+	}
+
+	return labels, false
+}
+
+// DriftedOrExpiredPredicate returns a predicate selecting the Instance objects marked as drifted
+// or expired through InstanceDriftedSelectorLabel or InstanceExpiredSelectorLabel, so that the
+// instance-automation controller can pick them up and mark them for termination, the same way it
+// already does for the termination selector label.
+func DriftedOrExpiredPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		labels := obj.GetLabels()
+		return labels[InstanceDriftedSelectorLabel] == "true" || labels[InstanceExpiredSelectorLabel] == "true"
+	})
+}
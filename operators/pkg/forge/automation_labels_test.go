@@ -0,0 +1,168 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/forge"
+)
+
+var _ = Describe("Automation labels forging", func() {
+	const (
+		driftInstanceName = "kubernetes-0001"
+		driftNamespace    = "tenant-tester"
+	)
+
+	var instance clv1alpha2.Instance
+	var template clv1alpha2.Template
+
+	BeforeEach(func() {
+		template = clv1alpha2.Template{
+			Spec: clv1alpha2.TemplateSpec{
+				EnvironmentList: []clv1alpha2.Environment{
+					{Name: "control-plane", Image: "some/image:v1", Persistent: true},
+				},
+			},
+		}
+		instance = clv1alpha2.Instance{
+			ObjectMeta: metav1.ObjectMeta{Name: driftInstanceName, Namespace: driftNamespace},
+		}
+	})
+
+	Describe("The forge.InstanceDriftLabels function", func() {
+		type DriftCase struct {
+			Annotation      string
+			ExpectedDrifted bool
+		}
+
+		DescribeTable("Correctly detects the drifted Instances",
+			func(c DriftCase) {
+				if c.Annotation != "" {
+					instance.Annotations = map[string]string{forge.SpecHashAnnotation: c.Annotation}
+				}
+
+				output, updated := forge.InstanceDriftLabels(map[string]string{}, &instance, &template)
+
+				if c.ExpectedDrifted {
+					Expect(output).To(HaveKeyWithValue(forge.InstanceDriftedSelectorLabel, "true"))
+					Expect(updated).To(BeTrue())
+				} else {
+					Expect(output).NotTo(HaveKey(forge.InstanceDriftedSelectorLabel))
+				}
+			},
+			Entry("When the annotation matches the current hash", DriftCase{
+				Annotation:      forge.SpecHash(&clv1alpha2.Instance{}, &clv1alpha2.Template{Spec: clv1alpha2.TemplateSpec{EnvironmentList: []clv1alpha2.Environment{{Name: "control-plane", Image: "some/image:v1", Persistent: true}}}}),
+				ExpectedDrifted: false,
+			}),
+			Entry("When the annotation differs from the current hash", DriftCase{
+				Annotation:      "outdated-hash",
+				ExpectedDrifted: true,
+			}),
+			Entry("When the annotation is missing", DriftCase{
+				Annotation:      "",
+				ExpectedDrifted: false,
+			}),
+		)
+
+		It("Does not mutate the input labels map", func() {
+			input := map[string]string{"user/key": "user/value"}
+			expected := map[string]string{"user/key": "user/value"}
+			instance.Annotations = map[string]string{forge.SpecHashAnnotation: "outdated-hash"}
+
+			forge.InstanceDriftLabels(input, &instance, &template)
+
+			Expect(input).To(Equal(expected))
+		})
+
+		It("Is idempotent", func() {
+			instance.Annotations = map[string]string{forge.SpecHashAnnotation: "outdated-hash"}
+
+			first, _ := forge.InstanceDriftLabels(map[string]string{}, &instance, &template)
+			second, updated := forge.InstanceDriftLabels(first, &instance, &template)
+
+			Expect(second).To(Equal(first))
+			Expect(updated).To(BeFalse())
+		})
+
+		It("Tolerates a nil input labels map", func() {
+			Expect(func() { forge.InstanceDriftLabels(nil, &instance, &template) }).NotTo(Panic())
+		})
+	})
+
+	Describe("The forge.InstanceExpirationLabels function", func() {
+		type ExpirationCase struct {
+			DeleteAfter     string
+			Age             time.Duration
+			ExpectedExpired bool
+		}
+
+		DescribeTable("Correctly detects the expired Instances",
+			func(c ExpirationCase) {
+				template.Spec.DeleteAfter = c.DeleteAfter
+				instance.CreationTimestamp = metav1.NewTime(time.Now().Add(-c.Age))
+
+				output, _ := forge.InstanceExpirationLabels(map[string]string{}, &instance, &template, time.Now())
+
+				if c.ExpectedExpired {
+					Expect(output).To(HaveKeyWithValue(forge.InstanceExpiredSelectorLabel, "true"))
+				} else {
+					Expect(output).NotTo(HaveKey(forge.InstanceExpiredSelectorLabel))
+				}
+			},
+			Entry("When the DeleteAfter duration has elapsed", ExpirationCase{
+				DeleteAfter:     "1",
+				Age:             48 * time.Hour,
+				ExpectedExpired: true,
+			}),
+			Entry("When the DeleteAfter duration has not elapsed yet", ExpirationCase{
+				DeleteAfter:     "7",
+				Age:             1 * time.Hour,
+				ExpectedExpired: false,
+			}),
+			Entry("When DeleteAfter is zero", ExpirationCase{
+				DeleteAfter:     "0",
+				Age:             48 * time.Hour,
+				ExpectedExpired: false,
+			}),
+			Entry("When DeleteAfter is never", ExpirationCase{
+				DeleteAfter:     "never",
+				Age:             365 * 24 * time.Hour,
+				ExpectedExpired: false,
+			}),
+		)
+
+		It("Does not mutate the input labels map", func() {
+			input := map[string]string{"user/key": "user/value"}
+			expected := map[string]string{"user/key": "user/value"}
+			template.Spec.DeleteAfter = "1"
+			instance.CreationTimestamp = metav1.NewTime(time.Now().Add(-48 * time.Hour))
+
+			forge.InstanceExpirationLabels(input, &instance, &template, time.Now())
+
+			Expect(input).To(Equal(expected))
+		})
+
+		It("Tolerates a nil input labels map", func() {
+			Expect(func() { forge.InstanceExpirationLabels(nil, &instance, &template, time.Now()) }).NotTo(Panic())
+		})
+	})
+})
@@ -0,0 +1,239 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+
+	clv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+// LabelEvalErrorCondition is the condition type set on the Instance status when one or more
+// Template.Spec.LabelExprs fail to evaluate, so that the error is surfaced to the user without
+// failing the reconciliation.
+const LabelEvalErrorCondition = "crownlabs.polito.it/label-eval-error"
+
+// maxLabelLength is the maximum length of a valid Kubernetes label value.
+const maxLabelLength = 63
+
+// reservedLabelExprKeys lists the crownlabs.polito.it/* keys a LabelExprs entry is never allowed
+// to override, because they track automation/schema state the rest of the operator relies on
+// (as opposed to the identity labels such as workspace/template, which an expression may
+// deliberately override).
+var reservedLabelExprKeys = map[string]struct{}{
+	InstanceTerminationSelectorLabel: {},
+	InstanceSubmitterSelectorLabel:   {},
+	labelSchemaLabel:                 {},
+}
+
+// evaluateLabelExprs evaluates the given CEL MapExpr list against a context built out of the
+// Instance, Template, Tenant, Workspace and the resolved Environment, merging the results into
+// labels after the built-in ones so that an expression may deliberately override them. Keys in
+// reservedLabelExprKeys are never overridden. Expressions returning an empty string are skipped.
+// It returns one error per failing expression, the caller being responsible for surfacing them
+// without aborting the reconciliation, together with a boolean indicating whether labels was
+// modified as a result.
+func evaluateLabelExprs(labels map[string]string, exprs []clv1alpha2.MapExpr,
+	instance *clv1alpha2.Instance, template *clv1alpha2.Template, tenant *clv1alpha2.Tenant, workspace *clv1alpha2.Workspace) ([]error, bool) {
+	env, err := newLabelExprEnv(labels, tenant)
+	if err != nil {
+		return []error{fmt.Errorf("failed to build the CEL environment: %w", err)}, false
+	}
+
+	vars := map[string]interface{}{
+		"instance":    instanceToCelMap(instance),
+		"template":    templateToCelMap(template),
+		"tenant":      tenantToCelMap(tenant),
+		"workspace":   workspaceToCelMap(workspace),
+		"environment": resolvedEnvironmentToCelMap(template),
+	}
+
+	var errs []error
+	updated := false
+	for _, expr := range exprs {
+		key, err := evalCelString(env, vars, expr.KeyExpr, expr.Key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to evaluate the key expression %q: %w", expr.KeyExpr, err))
+			continue
+		}
+
+		value, err := evalCelString(env, vars, expr.ValueExpr, expr.Value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to evaluate the value expression %q: %w", expr.ValueExpr, err))
+			continue
+		}
+
+		if key == "" || value == "" {
+			continue
+		}
+
+		if _, reserved := reservedLabelExprKeys[key]; reserved {
+			continue
+		}
+
+		updated = updateLabel(labels, key, value) || updated
+	}
+
+	return errs, updated
+}
+
+// evalCelString evaluates the given CEL expression, falling back to the static value whenever
+// the expression is empty.
+func evalCelString(env *cel.Env, vars map[string]interface{}, expr, static string) (string, error) {
+	if expr == "" {
+		return static, nil
+	}
+
+	ast, iss := env.Compile(expr)
+	if iss != nil && iss.Err() != nil {
+		return "", iss.Err()
+	}
+
+	prg, err := env.Program(ast)
+	if err != nil {
+		return "", err
+	}
+
+	out, _, err := prg.Eval(vars)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%v", out.Value()), nil
+}
+
+// newLabelExprEnv builds the CEL environment used to evaluate the LabelExprs, registering the
+// hasLabel, tenantHasWorkspace and toLabelSafe helper functions.
+func newLabelExprEnv(labels map[string]string, tenant *clv1alpha2.Tenant) (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("instance", cel.DynType),
+		cel.Variable("template", cel.DynType),
+		cel.Variable("tenant", cel.DynType),
+		cel.Variable("workspace", cel.DynType),
+		cel.Variable("environment", cel.DynType),
+		cel.Function("hasLabel",
+			cel.Overload("hasLabel_string", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.UnaryBinding(func(key ref.Val) ref.Val {
+					_, found := labels[string(key.(types.String))]
+					return types.Bool(found)
+				}),
+			),
+		),
+		cel.Function("tenantHasWorkspace",
+			cel.Overload("tenantHasWorkspace_string", []*cel.Type{cel.StringType}, cel.BoolType,
+				cel.UnaryBinding(func(name ref.Val) ref.Val {
+					return types.Bool(tenant != nil && tenant.HasWorkspace(string(name.(types.String))))
+				}),
+			),
+		),
+		cel.Function("toLabelSafe",
+			cel.Overload("toLabelSafe_string", []*cel.Type{cel.StringType}, cel.StringType,
+				cel.UnaryBinding(func(s ref.Val) ref.Val {
+					return types.String(toLabelSafe(string(s.(types.String))))
+				}),
+			),
+		),
+	)
+}
+
+// toLabelSafe lowercases and trims the given string to 63 characters, replacing every character
+// which is not valid within a Kubernetes label value with a dash, and trimming any leading or
+// trailing dash/underscore/dot left over so the result is always a valid label value on its own.
+func toLabelSafe(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	runes := []rune(s)
+	if len(runes) > maxLabelLength {
+		runes = runes[:maxLabelLength]
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(runes))
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('-')
+		}
+	}
+
+	return strings.Trim(sb.String(), "-_.")
+}
+
+func instanceToCelMap(instance *clv1alpha2.Instance) map[string]interface{} {
+	if instance == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"name":       instance.Name,
+		"namespace":  instance.Namespace,
+		"prettyName": instance.Spec.PrettyName,
+		"running":    instance.Spec.Running,
+	}
+}
+
+func templateToCelMap(template *clv1alpha2.Template) map[string]interface{} {
+	if template == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"name":        template.Name,
+		"namespace":   template.Namespace,
+		"prettyName":  template.Spec.PrettyName,
+		"deleteAfter": template.Spec.DeleteAfter,
+	}
+}
+
+func tenantToCelMap(tenant *clv1alpha2.Tenant) map[string]interface{} {
+	if tenant == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"name":      tenant.Name,
+		"firstName": tenant.Spec.FirstName,
+		"lastName":  tenant.Spec.LastName,
+		"email":     tenant.Spec.Email,
+	}
+}
+
+func workspaceToCelMap(workspace *clv1alpha2.Workspace) map[string]interface{} {
+	if workspace == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"name":       workspace.Name,
+		"prettyName": workspace.Spec.PrettyName,
+	}
+}
+
+func resolvedEnvironmentToCelMap(template *clv1alpha2.Template) map[string]interface{} {
+	if template == nil || len(template.Spec.EnvironmentList) == 0 {
+		return map[string]interface{}{}
+	}
+
+	env := template.Spec.EnvironmentList[0]
+	return map[string]interface{}{
+		"name":       env.Name,
+		"persistent": env.Persistent,
+		"gui":        env.GuiEnabled,
+		"image":      env.Image,
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	clv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+// applyLabelInjectionPolicies merges into labels the payload of every policy, among the ones
+// passed in (in order), whose selector matches the synthetic label set built so far. The
+// built-in crownlabs.polito.it/* keys always win, and are never overwritten by a policy. It
+// returns whether labels has been modified as a result.
+func applyLabelInjectionPolicies(labelsMap map[string]string, policies []clv1alpha2.LabelInjectionPolicy) bool {
+	updated := false
+
+	for i := range policies {
+		policy := &policies[i]
+
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(labelsMap)) {
+			continue
+		}
+
+		for key, value := range policy.Spec.Labels {
+			if strings.HasPrefix(key, crownLabsPrefix) {
+				continue
+			}
+			updated = updateLabel(labelsMap, key, value) || updated
+		}
+	}
+
+	return updated
+}
+
+// applyAnnotationInjectionPolicies merges into annotations the Spec.Annotations payload of every
+// policy, among the ones passed in (in order), whose selector matches the given Instance labels.
+// It returns whether annotations has been modified as a result.
+func applyAnnotationInjectionPolicies(annotations, instanceLabels map[string]string, policies []clv1alpha2.LabelInjectionPolicy) bool {
+	updated := false
+
+	for i := range policies {
+		policy := &policies[i]
+
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(instanceLabels)) {
+			continue
+		}
+
+		for key, value := range policy.Spec.Annotations {
+			updated = updateLabel(annotations, key, value) || updated
+		}
+	}
+
+	return updated
+}
+
+// InstanceAnnotations receives in input a set of annotations and returns the updated set, merging
+// in the Spec.Annotations payload of every LabelInjectionPolicy whose selector matches the given
+// Instance labels (as produced by InstanceLabels). It returns the updated set of annotations,
+// together with a boolean value indicating whether it differs from the input one.
+func InstanceAnnotations(annotations, instanceLabels map[string]string, policies []clv1alpha2.LabelInjectionPolicy) (map[string]string, bool) {
+	annotations = deepCopyLabels(annotations)
+	updated := applyAnnotationInjectionPolicies(annotations, instanceLabels, policies)
+	return annotations, updated
+}
+
+// InstanceObjectAnnotations returns the annotations to be attached to the objects (e.g. Pod,
+// Service, ...) generated in the context of a given Instance, merging in the Spec.Annotations
+// payload of every LabelInjectionPolicy whose selector matches the given Instance labels (as
+// produced by InstanceObjectLabels).
+func InstanceObjectAnnotations(annotations, instanceLabels map[string]string, policies []clv1alpha2.LabelInjectionPolicy) map[string]string {
+	annotations = deepCopyLabels(annotations)
+	applyAnnotationInjectionPolicies(annotations, instanceLabels, policies)
+	return annotations
+}
@@ -0,0 +1,132 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"strconv"
+
+	clv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+const (
+	// LabelSchemaVersion is the current version of the CrownLabs label schema, written as
+	// labelSchemaLabel on every label set produced through the LabelSet builder.
+	LabelSchemaVersion = "v2"
+
+	labelSchemaLabel = crownLabsPrefix + "label-schema"
+
+	// legacyWorkspaceRefLabel is the v1 key carrying the same information now tracked by
+	// workspaceLabel, kept around for MigrateLabels to translate.
+	legacyWorkspaceRefLabel = crownLabsPrefix + "workspace-ref"
+	// ephemeralStorageLabel is the v2 counterpart of persistentLabel, introduced so that callers
+	// no longer have to negate the persistent flag to know whether an Instance is ephemeral.
+	ephemeralStorageLabel = crownLabsPrefix + "ephemeral-storage"
+)
+
+// LabelSet is a typed representation of the crownlabs.polito.it/* labels attached to a given
+// object. It is built through NewLabelSet and a chain of With* methods, and turned into a plain
+// map[string]string through Build. InstanceLabels and InstanceObjectLabels use it internally to
+// compute the desired set of built-in labels, which is then merged into the pre-existing map
+// while tracking whether it changed.
+type LabelSet struct {
+	labels map[string]string
+}
+
+// NewLabelSet returns an empty LabelSet, already stamped with the current LabelSchemaVersion.
+func NewLabelSet() *LabelSet {
+	return &LabelSet{labels: map[string]string{labelSchemaLabel: LabelSchemaVersion}}
+}
+
+// WithLabel sets an arbitrary key/value pair, as an escape hatch for the callers within the forge
+// package needing to set a label not covered by a dedicated With* method.
+func (ls *LabelSet) WithLabel(key, value string) *LabelSet {
+	ls.labels[key] = value
+	return ls
+}
+
+// WithManagedBy sets the managed-by label to the given value.
+func (ls *LabelSet) WithManagedBy(managedBy string) *LabelSet {
+	ls.labels[managedByLabel] = managedBy
+	return ls
+}
+
+// WithTemplate sets the workspace, template and persistent/ephemeral-storage labels out of the
+// given Template.
+func (ls *LabelSet) WithTemplate(template *clv1alpha2.Template) *LabelSet {
+	if template == nil {
+		return ls
+	}
+
+	persistent := hasPersistentEnvironment(template)
+	ls.labels[workspaceLabel] = template.Spec.WorkspaceRef.Name
+	ls.labels[templateLabel] = template.Name
+	ls.labels[persistentLabel] = strconv.FormatBool(persistent)
+	ls.labels[ephemeralStorageLabel] = strconv.FormatBool(!persistent)
+	return ls
+}
+
+// WithTenant sets the tenant label out of the given Tenant.
+func (ls *LabelSet) WithTenant(tenant *clv1alpha2.Tenant) *LabelSet {
+	if tenant != nil {
+		ls.labels[tenantLabel] = tenant.Name
+	}
+	return ls
+}
+
+// WithInstance sets the instance label out of the given Instance.
+func (ls *LabelSet) WithInstance(instance *clv1alpha2.Instance) *LabelSet {
+	if instance != nil {
+		ls.labels[instanceLabel] = instance.Name
+	}
+	return ls
+}
+
+// WithAutomation sets the termination and submitter selector labels.
+func (ls *LabelSet) WithAutomation(watchForTermination, submitOnTermination bool) *LabelSet {
+	ls.labels[InstanceTerminationSelectorLabel] = strconv.FormatBool(watchForTermination)
+	ls.labels[InstanceSubmitterSelectorLabel] = strconv.FormatBool(submitOnTermination)
+	return ls
+}
+
+// Build returns the map[string]string corresponding to the LabelSet built so far.
+func (ls *LabelSet) Build() map[string]string {
+	return deepCopyLabels(ls.labels)
+}
+
+// MigrateLabels upgrades the given labels map, as produced under an older version of the
+// CrownLabs label schema (or under no schema at all), to the current LabelSchemaVersion. It
+// returns the migrated labels, together with a boolean indicating whether a write is needed to
+// persist the migration. Labels it does not recognize (e.g. user-provided ones) are preserved
+// untouched.
+func MigrateLabels(existing map[string]string) (map[string]string, bool) {
+	labels := deepCopyLabels(existing)
+
+	if labels[labelSchemaLabel] == LabelSchemaVersion {
+		return labels, false
+	}
+
+	if value, found := labels[legacyWorkspaceRefLabel]; found {
+		delete(labels, legacyWorkspaceRefLabel)
+		labels[workspaceLabel] = value
+	}
+
+	if value, found := labels[persistentLabel]; found {
+		labels[ephemeralStorageLabel] = strconv.FormatBool(value != strconv.FormatBool(true))
+	}
+
+	labels[labelSchemaLabel] = LabelSchemaVersion
+
+	return labels, true
+}
@@ -0,0 +1,119 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/forge"
+)
+
+var _ = Describe("The forge.LabelSet builder", func() {
+	It("Builds the expected map out of a fluent chain of calls", func() {
+		template := &clv1alpha2.Template{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes"},
+			Spec: clv1alpha2.TemplateSpec{
+				WorkspaceRef:    clv1alpha2.GenericRef{Name: "netgroup"},
+				EnvironmentList: []clv1alpha2.Environment{{Persistent: true}},
+			},
+		}
+		tenant := &clv1alpha2.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "tester"}}
+
+		output := forge.NewLabelSet().WithManagedBy("instance").WithTemplate(template).WithTenant(tenant).
+			WithAutomation(true, false).Build()
+
+		Expect(output).To(Equal(map[string]string{
+			"crownlabs.polito.it/label-schema":      forge.LabelSchemaVersion,
+			"crownlabs.polito.it/managed-by":        "instance",
+			"crownlabs.polito.it/workspace":         "netgroup",
+			"crownlabs.polito.it/template":          "kubernetes",
+			"crownlabs.polito.it/persistent":        "true",
+			"crownlabs.polito.it/ephemeral-storage": "false",
+			"crownlabs.polito.it/tenant":            "tester",
+			forge.InstanceTerminationSelectorLabel:  "true",
+			forge.InstanceSubmitterSelectorLabel:    "false",
+		}))
+	})
+})
+
+var _ = Describe("The forge.MigrateLabels function", func() {
+	type MigrateCase struct {
+		Input           map[string]string
+		ExpectedOutput  map[string]string
+		ExpectedUpdated bool
+	}
+
+	DescribeTable("Correctly migrates the labels to the current schema",
+		func(c MigrateCase) {
+			output, updated := forge.MigrateLabels(c.Input)
+			Expect(output).To(Equal(c.ExpectedOutput))
+			Expect(updated).To(Equal(c.ExpectedUpdated))
+		},
+		Entry("When migrating a legacy v1 non-persistent Instance", MigrateCase{
+			Input: map[string]string{
+				"crownlabs.polito.it/workspace-ref": "netgroup",
+				"crownlabs.polito.it/persistent":    "false",
+				"user/key":                          "user/value",
+			},
+			ExpectedOutput: map[string]string{
+				"crownlabs.polito.it/workspace":         "netgroup",
+				"crownlabs.polito.it/persistent":        "false",
+				"crownlabs.polito.it/ephemeral-storage": "true",
+				"crownlabs.polito.it/label-schema":      forge.LabelSchemaVersion,
+				"user/key":                              "user/value",
+			},
+			ExpectedUpdated: true,
+		}),
+		Entry("When migrating a legacy v1 persistent Instance", MigrateCase{
+			Input: map[string]string{
+				"crownlabs.polito.it/workspace-ref": "netgroup",
+				"crownlabs.polito.it/persistent":    "true",
+			},
+			ExpectedOutput: map[string]string{
+				"crownlabs.polito.it/workspace":         "netgroup",
+				"crownlabs.polito.it/persistent":        "true",
+				"crownlabs.polito.it/ephemeral-storage": "false",
+				"crownlabs.polito.it/label-schema":      forge.LabelSchemaVersion,
+			},
+			ExpectedUpdated: true,
+		}),
+		Entry("When the labels are already at the current schema version", MigrateCase{
+			Input: map[string]string{
+				"crownlabs.polito.it/label-schema": forge.LabelSchemaVersion,
+				"crownlabs.polito.it/workspace":     "netgroup",
+				"user/key":                          "user/value",
+			},
+			ExpectedOutput: map[string]string{
+				"crownlabs.polito.it/label-schema": forge.LabelSchemaVersion,
+				"crownlabs.polito.it/workspace":     "netgroup",
+				"user/key":                          "user/value",
+			},
+			ExpectedUpdated: false,
+		}),
+	)
+
+	It("Does not mutate the input labels map", func() {
+		input := map[string]string{"crownlabs.polito.it/persistent": "true"}
+		expected := map[string]string{"crownlabs.polito.it/persistent": "true"}
+
+		forge.MigrateLabels(input)
+
+		Expect(input).To(Equal(expected))
+	})
+})
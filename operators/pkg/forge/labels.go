@@ -0,0 +1,204 @@
+// Copyright 2020-2022 Politecnico di Torino
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package forge groups the methods used to forge the K8s object definitions required by the
+// different controllers, in a centralized and consistent way.
+package forge
+
+import (
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
+)
+
+const (
+	// crownLabsPrefix is the common prefix of all the labels/annotations owned by CrownLabs,
+	// which LabelInjectionPolicy payloads are never allowed to override. Template LabelExprs may
+	// override them, except the keys listed in reservedLabelExprKeys.
+	crownLabsPrefix = "crownlabs.polito.it/"
+
+	managedByLabel  = crownLabsPrefix + "managed-by"
+	workspaceLabel  = crownLabsPrefix + "workspace"
+	templateLabel   = crownLabsPrefix + "template"
+	persistentLabel = crownLabsPrefix + "persistent"
+	instanceLabel   = crownLabsPrefix + "instance"
+	tenantLabel     = crownLabsPrefix + "tenant"
+
+	// InstanceTerminationSelectorLabel is the label used to mark an Instance as subject to the
+	// automatic termination logic performed by the instance-automation controller.
+	InstanceTerminationSelectorLabel = crownLabsPrefix + "watch-for-termination"
+	// InstanceSubmitterSelectorLabel is the label used to mark an Instance as subject to the
+	// automatic submission of its final status, performed upon termination.
+	InstanceSubmitterSelectorLabel = crownLabsPrefix + "submit-on-termination"
+)
+
+// InstanceLabels receives in input a set of labels and returns the updated set depending on the
+// characteristics of the Instance, identified by the given Template, Tenant, Workspace and
+// customization URLs, further merging the payload of every matching LabelInjectionPolicy (in
+// order, with the built-in crownlabs.polito.it/* keys always winning). It returns the updated set
+// of labels, together with a boolean value indicating whether it differs from the input one
+// (hence, whether it should be updated).
+func InstanceLabels(labels map[string]string, instance *clv1alpha2.Instance, template *clv1alpha2.Template,
+	tenant *clv1alpha2.Tenant, workspace *clv1alpha2.Workspace, customizationUrls *clv1alpha2.InstanceCustomizationUrls,
+	policies []clv1alpha2.LabelInjectionPolicy) (map[string]string, bool) {
+	updated := false
+	original := labels
+	labels = deepCopyLabels(labels)
+
+	desired := NewLabelSet().WithManagedBy("instance").WithTemplate(template).Build()
+	for key, value := range desired {
+		updated = updateLabel(labels, key, value) || updated
+	}
+
+	if customizationUrls != nil && customizationUrls.StatusCheck != "" {
+		if _, found := labels[InstanceTerminationSelectorLabel]; !found {
+			updated = updateLabel(labels, InstanceTerminationSelectorLabel, strconv.FormatBool(true)) || updated
+		}
+	}
+
+	updated = applyLabelInjectionPolicies(labels, policies) || updated
+
+	if len(template.Spec.LabelExprs) > 0 {
+		errs, exprsUpdated := evaluateLabelExprs(labels, template.Spec.LabelExprs, instance, template, tenant, workspace)
+		setLabelEvalErrorCondition(instance, errs)
+		updated = updated || exprsUpdated
+	}
+
+	if updated {
+		// A later step (e.g. a LabelExprs entry) may restore a value changed by an earlier one,
+		// hence the result is compared against the input to tell whether it actually differs.
+		updated = len(labels) != len(original)
+		for key, value := range original {
+			if current, found := labels[key]; !found || current != value {
+				updated = true
+			}
+		}
+	}
+
+	return labels, updated
+}
+
+// InstanceObjectLabels receives in input a set of labels and returns the updated set so that it
+// correctly refers to the given Instance, together with its Template and Tenant, further merging
+// the payload of every matching LabelInjectionPolicy (in order, with the built-in
+// crownlabs.polito.it/* keys always winning) and, when the Template configures any, the result of
+// its LabelExprs (which may override the built-in keys, except the automation and label-schema
+// ones). It is used for the objects (e.g. Pod, Service, ...) generated in the context of a
+// given Instance, as opposed to the Instance object itself.
+func InstanceObjectLabels(labels map[string]string, instance *clv1alpha2.Instance, template *clv1alpha2.Template,
+	policies []clv1alpha2.LabelInjectionPolicy) map[string]string {
+	labels = deepCopyLabels(labels)
+
+	desired := NewLabelSet().
+		WithManagedBy("instance").
+		WithLabel(instanceLabel, instance.Name).
+		WithLabel(templateLabel, instance.Spec.Template.Name).
+		WithLabel(tenantLabel, instance.Spec.Tenant.Name).
+		Build()
+	for key, value := range desired {
+		updateLabel(labels, key, value)
+	}
+
+	applyLabelInjectionPolicies(labels, policies)
+
+	if template != nil && len(template.Spec.LabelExprs) > 0 {
+		// Errors are already surfaced on the Instance status by the InstanceLabels call made
+		// against the same Template earlier in the same reconcile, so they are discarded here.
+		_, _ = evaluateLabelExprs(labels, template.Spec.LabelExprs, instance, template, nil, nil)
+	}
+
+	return labels
+}
+
+// InstanceSelectorLabels returns the set of labels uniquely identifying the objects generated in
+// the context of a given Instance, to be used e.g. as a label selector.
+func InstanceSelectorLabels(instance *clv1alpha2.Instance) map[string]string {
+	return map[string]string{
+		instanceLabel: instance.Name,
+		templateLabel: instance.Spec.Template.Name,
+		tenantLabel:   instance.Spec.Tenant.Name,
+	}
+}
+
+// InstanceAutomationLabelsOnTermination receives in input a set of labels and returns the updated
+// set so that the Instance is no longer watched for automatic termination, while being marked for
+// the submission of its final status.
+func InstanceAutomationLabelsOnTermination(labels map[string]string) map[string]string {
+	labels = deepCopyLabels(labels)
+
+	updateLabel(labels, InstanceTerminationSelectorLabel, strconv.FormatBool(false))
+	updateLabel(labels, InstanceSubmitterSelectorLabel, strconv.FormatBool(true))
+
+	return labels
+}
+
+// hasPersistentEnvironment returns whether at least one of the environments composing the given
+// Template is persistent.
+func hasPersistentEnvironment(template *clv1alpha2.Template) bool {
+	for i := range template.Spec.EnvironmentList {
+		if template.Spec.EnvironmentList[i].Persistent {
+			return true
+		}
+	}
+	return false
+}
+
+// updateLabel sets the given key/value pair into the labels map, returning whether the map has
+// been modified as a result of the operation.
+func updateLabel(labels map[string]string, key, value string) bool {
+	if current, found := labels[key]; found && current == value {
+		return false
+	}
+	labels[key] = value
+	return true
+}
+
+// deepCopyLabels returns a copy of the given labels map, so that the input one is never mutated.
+func deepCopyLabels(labels map[string]string) map[string]string {
+	output := make(map[string]string, len(labels))
+	for k, v := range labels {
+		output[k] = v
+	}
+	return output
+}
+
+// setLabelEvalErrorCondition records the outcome of the LabelExprs evaluation on the Instance
+// status, without failing the reconciliation in case of errors.
+func setLabelEvalErrorCondition(instance *clv1alpha2.Instance, errs []error) {
+	if instance == nil {
+		return
+	}
+
+	status := metav1.ConditionFalse
+	message := "All the label expressions were successfully evaluated"
+	if len(errs) > 0 {
+		status = metav1.ConditionTrue
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		message = strings.Join(messages, "; ")
+	}
+
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:    LabelEvalErrorCondition,
+		Status:  status,
+		Reason:  "LabelExprEvaluation",
+		Message: message,
+	})
+}
@@ -20,6 +20,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	clv1alpha2 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha2"
@@ -41,6 +42,7 @@ var _ = Describe("Labels forging", func() {
 
 	Describe("The forge.InstanceLabels function", func() {
 		var template clv1alpha2.Template
+		var instance clv1alpha2.Instance
 
 		type InstanceLabelsCase struct {
 			Input           map[string]string
@@ -66,11 +68,14 @@ var _ = Describe("Labels forging", func() {
 					WorkspaceRef: clv1alpha2.GenericRef{Name: workspaceName},
 				},
 			}
+			instance = clv1alpha2.Instance{
+				ObjectMeta: metav1.ObjectMeta{Name: instanceName, Namespace: instanceNamespace},
+			}
 		})
 
 		DescribeTable("Correctly populates the labels set",
 			func(c InstanceLabelsCase) {
-				output, updated := forge.InstanceLabels(c.Input, &template, nil)
+				output, updated := forge.InstanceLabels(c.Input, &instance, &template, nil, nil, nil, nil)
 
 				Expect(output).To(Equal(c.ExpectedOutput))
 				Expect(updated).To(BeIdenticalTo(c.ExpectedUpdated))
@@ -78,27 +83,33 @@ var _ = Describe("Labels forging", func() {
 			Entry("When the input labels map is nil", InstanceLabelsCase{
 				Input: nil,
 				ExpectedOutput: map[string]string{
-					"crownlabs.polito.it/managed-by": "instance",
-					"crownlabs.polito.it/workspace":  workspaceName,
-					"crownlabs.polito.it/template":   templateName,
-					"crownlabs.polito.it/persistent": "false",
+					"crownlabs.polito.it/managed-by":        "instance",
+					"crownlabs.polito.it/workspace":         workspaceName,
+					"crownlabs.polito.it/template":          templateName,
+					"crownlabs.polito.it/persistent":        "false",
+					"crownlabs.polito.it/ephemeral-storage": "true",
+					"crownlabs.polito.it/label-schema":      forge.LabelSchemaVersion,
 				},
 				ExpectedUpdated: true,
 			}),
 			Entry("When the input labels map already contains the expected values", InstanceLabelsCase{
 				Input: map[string]string{
-					"crownlabs.polito.it/managed-by": "instance",
-					"crownlabs.polito.it/workspace":  workspaceName,
-					"crownlabs.polito.it/template":   templateName,
-					"crownlabs.polito.it/persistent": "false",
-					"user/key":                       "user/value",
+					"crownlabs.polito.it/managed-by":        "instance",
+					"crownlabs.polito.it/workspace":         workspaceName,
+					"crownlabs.polito.it/template":          templateName,
+					"crownlabs.polito.it/persistent":        "false",
+					"crownlabs.polito.it/ephemeral-storage": "true",
+					"crownlabs.polito.it/label-schema":      forge.LabelSchemaVersion,
+					"user/key":                              "user/value",
 				},
 				ExpectedOutput: map[string]string{
-					"crownlabs.polito.it/managed-by": "instance",
-					"crownlabs.polito.it/workspace":  workspaceName,
-					"crownlabs.polito.it/template":   templateName,
-					"crownlabs.polito.it/persistent": "false",
-					"user/key":                       "user/value",
+					"crownlabs.polito.it/managed-by":        "instance",
+					"crownlabs.polito.it/workspace":         workspaceName,
+					"crownlabs.polito.it/template":          templateName,
+					"crownlabs.polito.it/persistent":        "false",
+					"crownlabs.polito.it/ephemeral-storage": "true",
+					"crownlabs.polito.it/label-schema":      forge.LabelSchemaVersion,
+					"user/key":                              "user/value",
 				},
 				ExpectedUpdated: false,
 			}),
@@ -108,11 +119,13 @@ var _ = Describe("Labels forging", func() {
 					"user/key":                      "user/value",
 				},
 				ExpectedOutput: map[string]string{
-					"crownlabs.polito.it/managed-by": "instance",
-					"crownlabs.polito.it/workspace":  workspaceName,
-					"crownlabs.polito.it/template":   templateName,
-					"crownlabs.polito.it/persistent": "false",
-					"user/key":                       "user/value",
+					"crownlabs.polito.it/managed-by":        "instance",
+					"crownlabs.polito.it/workspace":         workspaceName,
+					"crownlabs.polito.it/template":          templateName,
+					"crownlabs.polito.it/persistent":        "false",
+					"crownlabs.polito.it/ephemeral-storage": "true",
+					"crownlabs.polito.it/label-schema":      forge.LabelSchemaVersion,
+					"user/key":                              "user/value",
 				},
 				ExpectedUpdated: true,
 			}),
@@ -121,7 +134,7 @@ var _ = Describe("Labels forging", func() {
 		DescribeTable("Correctly configures the persistent label",
 			func(c InstancePersistentLabelCase) {
 				template.Spec.EnvironmentList = c.EnvironmentList
-				output, _ := forge.InstanceLabels(map[string]string{}, &template, nil)
+				output, _ := forge.InstanceLabels(map[string]string{}, &instance, &template, nil, nil, nil, nil)
 				Expect(output).To(HaveKeyWithValue("crownlabs.polito.it/persistent", c.ExpectedValue))
 			},
 			Entry("When a single, non-persistent environment is present", InstancePersistentLabelCase{
@@ -148,7 +161,7 @@ var _ = Describe("Labels forging", func() {
 
 		DescribeTable("Correctly configures the automation labels",
 			func(c InstanceAutomationLabelCase) {
-				output, _ := forge.InstanceLabels(c.Input, &template, c.InstanceCustomizationUrls)
+				output, _ := forge.InstanceLabels(c.Input, &instance, &template, nil, nil, c.InstanceCustomizationUrls, nil)
 				if c.ExpectedValue != "" {
 					Expect(output).To(HaveKeyWithValue(forge.InstanceTerminationSelectorLabel, c.ExpectedValue))
 				} else {
@@ -187,9 +200,242 @@ var _ = Describe("Labels forging", func() {
 				expectedInput = map[string]string{"crownlabs.polito.it/managed-by": "whatever"}
 			})
 
-			JustBeforeEach(func() { forge.InstanceLabels(input, &template, nil) })
+			JustBeforeEach(func() { forge.InstanceLabels(input, &instance, &template, nil, nil, nil, nil) })
 			It("The original labels map is not modified", func() { Expect(input).To(Equal(expectedInput)) })
 		})
+
+		Describe("Evaluating the Template LabelExprs", func() {
+			type LabelExprCase struct {
+				LabelExprs          []clv1alpha2.MapExpr
+				ExpectedLabel       string
+				ExpectedValue       string
+				ExpectedLabelAbsent bool
+				ExpectOtherLabels   bool
+				ExpectErrorCond     bool
+			}
+
+			DescribeTable("Correctly merges the computed labels",
+				func(c LabelExprCase) {
+					template.Spec.LabelExprs = c.LabelExprs
+
+					output, _ := forge.InstanceLabels(map[string]string{}, &instance, &template, nil, nil, nil, nil)
+
+					if c.ExpectedLabelAbsent {
+						Expect(output).NotTo(HaveKey(c.ExpectedLabel))
+					} else {
+						Expect(output).To(HaveKeyWithValue(c.ExpectedLabel, c.ExpectedValue))
+					}
+
+					if c.ExpectOtherLabels {
+						Expect(output).To(HaveKeyWithValue("crownlabs.polito.it/template", templateName))
+					}
+
+					cond := meta.FindStatusCondition(instance.Status.Conditions, forge.LabelEvalErrorCondition)
+					if len(c.LabelExprs) == 0 {
+						Expect(cond).To(BeNil())
+						return
+					}
+
+					Expect(cond).NotTo(BeNil())
+					if c.ExpectErrorCond {
+						Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+					} else {
+						Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+					}
+				},
+				Entry("When the expr list is nil", LabelExprCase{
+					LabelExprs:          nil,
+					ExpectedLabelAbsent: true,
+				}),
+				Entry("When an expr overrides a built-in label", LabelExprCase{
+					LabelExprs: []clv1alpha2.MapExpr{
+						{Key: "crownlabs.polito.it/template", Value: "overridden"},
+					},
+					ExpectedLabel: "crownlabs.polito.it/template",
+					ExpectedValue: "overridden",
+				}),
+				Entry("When an expr returns an empty string", LabelExprCase{
+					LabelExprs: []clv1alpha2.MapExpr{
+						{Key: "course", ValueExpr: `""`},
+					},
+					ExpectedLabel:       "course",
+					ExpectedLabelAbsent: true,
+				}),
+				Entry("When an expr references a missing field", LabelExprCase{
+					LabelExprs: []clv1alpha2.MapExpr{
+						{Key: "course", ValueExpr: "workspace.missingField"},
+						{Key: "other", Value: "applied"},
+					},
+					ExpectedLabel:     "other",
+					ExpectedValue:     "applied",
+					ExpectOtherLabels: true,
+					ExpectErrorCond:   true,
+				}),
+				Entry("When an expr attempts to override the termination automation label", LabelExprCase{
+					LabelExprs: []clv1alpha2.MapExpr{
+						{Key: forge.InstanceTerminationSelectorLabel, Value: "false"},
+					},
+					ExpectedLabel:       forge.InstanceTerminationSelectorLabel,
+					ExpectedLabelAbsent: true,
+				}),
+				Entry("When an expr attempts to override the label-schema value", LabelExprCase{
+					LabelExprs: []clv1alpha2.MapExpr{
+						{Key: "crownlabs.polito.it/label-schema", Value: "v99"},
+					},
+					ExpectedLabel: "crownlabs.polito.it/label-schema",
+					ExpectedValue: forge.LabelSchemaVersion,
+				}),
+				Entry("When an expr sanitizes a value with invalid characters at the edges", LabelExprCase{
+					LabelExprs: []clv1alpha2.MapExpr{
+						{Key: "course", ValueExpr: `toLabelSafe("#Operating Systems!")`},
+					},
+					ExpectedLabel: "course",
+					ExpectedValue: "operating-systems",
+				}),
+			)
+
+			It("Reports updated when an expr computes a new label on an already-converged built-in set", func() {
+				converged, _ := forge.InstanceLabels(map[string]string{}, &instance, &template, nil, nil, nil, nil)
+
+				template.Spec.LabelExprs = []clv1alpha2.MapExpr{
+					{Key: "course", Value: "os161"},
+				}
+
+				_, updated := forge.InstanceLabels(converged, &instance, &template, nil, nil, nil, nil)
+
+				Expect(updated).To(BeTrue())
+			})
+		})
+
+		Describe("Applying the LabelInjectionPolicy objects", func() {
+			type PolicyCase struct {
+				Policies      []clv1alpha2.LabelInjectionPolicy
+				ExpectedKey   string
+				ExpectedValue string
+				ExpectAbsent  bool
+			}
+
+			matchEverything := func() metav1.LabelSelector {
+				return metav1.LabelSelector{}
+			}
+
+			DescribeTable("Correctly merges the matching policies",
+				func(c PolicyCase) {
+					output, _ := forge.InstanceLabels(map[string]string{}, &instance, &template, nil, nil, nil, c.Policies)
+
+					if c.ExpectAbsent {
+						Expect(output).NotTo(HaveKey(c.ExpectedKey))
+					} else {
+						Expect(output).To(HaveKeyWithValue(c.ExpectedKey, c.ExpectedValue))
+					}
+				},
+				Entry("When no policy is configured", PolicyCase{
+					Policies:     nil,
+					ExpectedKey:  "cost-center",
+					ExpectAbsent: true,
+				}),
+				Entry("When one policy matches and adds a user label", PolicyCase{
+					Policies: []clv1alpha2.LabelInjectionPolicy{
+						{Spec: clv1alpha2.LabelInjectionPolicySpec{
+							Selector: matchEverything(),
+							Labels:   map[string]string{"cost-center": "cs101"},
+						}},
+					},
+					ExpectedKey:   "cost-center",
+					ExpectedValue: "cs101",
+				}),
+				Entry("When two policies overlap, the later one wins", PolicyCase{
+					Policies: []clv1alpha2.LabelInjectionPolicy{
+						{Spec: clv1alpha2.LabelInjectionPolicySpec{
+							Selector: matchEverything(),
+							Labels:   map[string]string{"cost-center": "cs101"},
+						}},
+						{Spec: clv1alpha2.LabelInjectionPolicySpec{
+							Selector: matchEverything(),
+							Labels:   map[string]string{"cost-center": "cs202"},
+						}},
+					},
+					ExpectedKey:   "cost-center",
+					ExpectedValue: "cs202",
+				}),
+				Entry("When a policy attempts to overwrite a built-in label", PolicyCase{
+					Policies: []clv1alpha2.LabelInjectionPolicy{
+						{Spec: clv1alpha2.LabelInjectionPolicySpec{
+							Selector: matchEverything(),
+							Labels:   map[string]string{"crownlabs.polito.it/managed-by": "hijacked"},
+						}},
+					},
+					ExpectedKey:   "crownlabs.polito.it/managed-by",
+					ExpectedValue: "instance",
+				}),
+				Entry("When the selector uses matchExpressions with In/NotIn", PolicyCase{
+					Policies: []clv1alpha2.LabelInjectionPolicy{
+						{Spec: clv1alpha2.LabelInjectionPolicySpec{
+							Selector: metav1.LabelSelector{
+								MatchExpressions: []metav1.LabelSelectorRequirement{
+									{Key: "crownlabs.polito.it/template", Operator: metav1.LabelSelectorOpIn, Values: []string{templateName}},
+									{Key: "crownlabs.polito.it/workspace", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"other-workspace"}},
+								},
+							},
+							Labels: map[string]string{"classroom": "lab-1"},
+						}},
+					},
+					ExpectedKey:   "classroom",
+					ExpectedValue: "lab-1",
+				}),
+			)
+		})
+	})
+
+	Describe("The forge.InstanceAnnotations and forge.InstanceObjectAnnotations functions", func() {
+		matchEverything := metav1.LabelSelector{}
+
+		type AnnotationsCase struct {
+			Policies        []clv1alpha2.LabelInjectionPolicy
+			ExpectedOutput  map[string]string
+			ExpectedUpdated bool
+		}
+
+		DescribeTable("Correctly merges the matching policies' annotations",
+			func(c AnnotationsCase) {
+				output, updated := forge.InstanceAnnotations(map[string]string{}, map[string]string{}, c.Policies)
+				Expect(output).To(Equal(c.ExpectedOutput))
+				Expect(updated).To(Equal(c.ExpectedUpdated))
+
+				objectOutput := forge.InstanceObjectAnnotations(map[string]string{}, map[string]string{}, c.Policies)
+				Expect(objectOutput).To(Equal(c.ExpectedOutput))
+			},
+			Entry("When no policy is configured", AnnotationsCase{
+				Policies:        nil,
+				ExpectedOutput:  map[string]string{},
+				ExpectedUpdated: false,
+			}),
+			Entry("When one policy matches and adds an annotation", AnnotationsCase{
+				Policies: []clv1alpha2.LabelInjectionPolicy{
+					{Spec: clv1alpha2.LabelInjectionPolicySpec{
+						Selector:    matchEverything,
+						Annotations: map[string]string{"cost-center/owner": "cs101"},
+					}},
+				},
+				ExpectedOutput:  map[string]string{"cost-center/owner": "cs101"},
+				ExpectedUpdated: true,
+			}),
+		)
+
+		It("Does not mutate the input annotations map", func() {
+			input := map[string]string{"user/key": "user/value"}
+			expected := map[string]string{"user/key": "user/value"}
+			policies := []clv1alpha2.LabelInjectionPolicy{
+				{Spec: clv1alpha2.LabelInjectionPolicySpec{
+					Selector:    matchEverything,
+					Annotations: map[string]string{"cost-center/owner": "cs101"},
+				}},
+			}
+
+			forge.InstanceAnnotations(input, map[string]string{}, policies)
+
+			Expect(input).To(Equal(expected))
+		})
 	})
 
 	Describe("The forge.InstanceObjectLabels function", func() {
@@ -212,31 +458,34 @@ var _ = Describe("Labels forging", func() {
 
 		DescribeTable("Correctly populates the labels set",
 			func(c ObjectLabelsCase) {
-				Expect(forge.InstanceObjectLabels(c.Input, &instance)).To(Equal(c.ExpectedOutput))
+				Expect(forge.InstanceObjectLabels(c.Input, &instance, nil, nil)).To(Equal(c.ExpectedOutput))
 			},
 			Entry("When the input labels map is nil", ObjectLabelsCase{
 				Input: nil,
 				ExpectedOutput: map[string]string{
-					"crownlabs.polito.it/managed-by": "instance",
-					"crownlabs.polito.it/instance":   instanceName,
-					"crownlabs.polito.it/template":   templateName,
-					"crownlabs.polito.it/tenant":     tenantName,
+					"crownlabs.polito.it/managed-by":   "instance",
+					"crownlabs.polito.it/instance":     instanceName,
+					"crownlabs.polito.it/template":     templateName,
+					"crownlabs.polito.it/tenant":       tenantName,
+					"crownlabs.polito.it/label-schema": forge.LabelSchemaVersion,
 				},
 			}),
 			Entry("When the input labels map already contains the expected values", ObjectLabelsCase{
 				Input: map[string]string{
-					"crownlabs.polito.it/managed-by": "instance",
-					"crownlabs.polito.it/instance":   instanceName,
-					"crownlabs.polito.it/template":   templateName,
-					"crownlabs.polito.it/tenant":     tenantName,
-					"user/key":                       "user/value",
+					"crownlabs.polito.it/managed-by":   "instance",
+					"crownlabs.polito.it/instance":     instanceName,
+					"crownlabs.polito.it/template":     templateName,
+					"crownlabs.polito.it/tenant":       tenantName,
+					"crownlabs.polito.it/label-schema": forge.LabelSchemaVersion,
+					"user/key":                         "user/value",
 				},
 				ExpectedOutput: map[string]string{
-					"crownlabs.polito.it/managed-by": "instance",
-					"crownlabs.polito.it/instance":   instanceName,
-					"crownlabs.polito.it/template":   templateName,
-					"crownlabs.polito.it/tenant":     tenantName,
-					"user/key":                       "user/value",
+					"crownlabs.polito.it/managed-by":   "instance",
+					"crownlabs.polito.it/instance":     instanceName,
+					"crownlabs.polito.it/template":     templateName,
+					"crownlabs.polito.it/tenant":       tenantName,
+					"crownlabs.polito.it/label-schema": forge.LabelSchemaVersion,
+					"user/key":                         "user/value",
 				},
 			}),
 			Entry("When the input labels map contains only part of the expected values", ObjectLabelsCase{
@@ -246,11 +495,12 @@ var _ = Describe("Labels forging", func() {
 					"user/key":                       "user/value",
 				},
 				ExpectedOutput: map[string]string{
-					"crownlabs.polito.it/managed-by": "instance",
-					"crownlabs.polito.it/instance":   instanceName,
-					"crownlabs.polito.it/template":   templateName,
-					"crownlabs.polito.it/tenant":     tenantName,
-					"user/key":                       "user/value",
+					"crownlabs.polito.it/managed-by":   "instance",
+					"crownlabs.polito.it/instance":     instanceName,
+					"crownlabs.polito.it/template":     templateName,
+					"crownlabs.polito.it/tenant":       tenantName,
+					"crownlabs.polito.it/label-schema": forge.LabelSchemaVersion,
+					"user/key":                         "user/value",
 				},
 			}),
 		)
@@ -263,9 +513,27 @@ var _ = Describe("Labels forging", func() {
 				expectedInput = map[string]string{"crownlabs.polito.it/managed-by": "whatever"}
 			})
 
-			JustBeforeEach(func() { forge.InstanceObjectLabels(input, &instance) })
+			JustBeforeEach(func() { forge.InstanceObjectLabels(input, &instance, nil, nil) })
 			It("The original labels map is not modified", func() { Expect(input).To(Equal(expectedInput)) })
 		})
+
+		Context("When the Template configures LabelExprs", func() {
+			It("Applies them the same way InstanceLabels does", func() {
+				template := clv1alpha2.Template{
+					ObjectMeta: metav1.ObjectMeta{Name: templateName, Namespace: templateNamespace},
+					Spec: clv1alpha2.TemplateSpec{
+						WorkspaceRef: clv1alpha2.GenericRef{Name: workspaceName},
+						LabelExprs: []clv1alpha2.MapExpr{
+							{Key: "classroom", Value: "lab-1"},
+						},
+					},
+				}
+
+				output := forge.InstanceObjectLabels(map[string]string{}, &instance, &template, nil)
+
+				Expect(output).To(HaveKeyWithValue("classroom", "lab-1"))
+			})
+		})
 	})
 
 	Describe("The forge.InstanceSelectorLabels function", func() {
@@ -292,7 +560,7 @@ var _ = Describe("Labels forging", func() {
 
 			It("Should be a subset of the object labels", func() {
 				selectorLabels := forge.InstanceSelectorLabels(&instance)
-				objectLabels := forge.InstanceObjectLabels(nil, &instance)
+				objectLabels := forge.InstanceObjectLabels(nil, &instance, nil, nil)
 				for key, value := range selectorLabels {
 					Expect(objectLabels).To(HaveKeyWithValue(key, value))
 				}